@@ -0,0 +1,61 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// ErrNoClientCertificate is returned by AuthenticateByClientCert when the
+// inbound request did not present a TLS client certificate at all, so the
+// caller can fall back to token-based auth instead of treating it as a
+// rejected remote.
+var ErrNoClientCertificate = errors.New("no tls client certificate presented")
+
+// AuthenticateByClientCert authenticates an inbound request to one of the
+// /api/v4/remotecluster/* endpoints by matching the TLS client certificate
+// the caller presented against the CA bundle on file for each known remote
+// cluster, as an alternative to the shared-secret token the api4 handlers
+// check first. It returns the matching remote, or ErrNoClientCertificate if
+// r was not served over mTLS, or an error if a cert was presented but
+// matched no remote.
+func (rcs *Service) AuthenticateByClientCert(r *http.Request) (*model.RemoteCluster, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoClientCertificate
+	}
+	peerLeaf := r.TLS.PeerCertificates[0]
+
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list remote clusters: %w", err)
+	}
+
+	for _, rc := range remotes {
+		if rc.CABundlePEM == "" {
+			continue
+		}
+
+		entry, err := rcs.tlsCache.entryFor(rc)
+		if err != nil {
+			continue
+		}
+		if entry.config == nil || entry.config.RootCAs == nil {
+			continue
+		}
+
+		if _, err := peerLeaf.Verify(x509.VerifyOptions{
+			Roots:     entry.config.RootCAs,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err == nil {
+			return rc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("presented client certificate does not match any known remote cluster")
+}
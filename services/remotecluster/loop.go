@@ -0,0 +1,254 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/mlog"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// SendQueuePollFreq bounds how long a task persisted while this node wasn't
+// the active leader can sit before sendLoop notices it.
+const SendQueuePollFreq = time.Second * 15
+
+// ErrRemoteClusterNotFound is returned when a sendTask references a remote
+// cluster id that no longer exists.
+var ErrRemoteClusterNotFound = errors.New("remote cluster not found")
+
+// SendMsg queues msg for delivery to rc. The task is persisted to
+// rcs.sendQueue before sendLoop is notified, so it survives a leader change
+// or restart even if it hasn't been attempted yet.
+func (rcs *Service) SendMsg(rc *model.RemoteCluster, msg model.RemoteClusterMsg) error {
+	task := sendTask{
+		RemoteId:       rc.RemoteId,
+		Msg:            msg,
+		IdempotencyKey: model.NewId(),
+	}
+	if err := rcs.sendQueue.Enqueue(task); err != nil {
+		return err
+	}
+
+	select {
+	case rcs.send <- task:
+	default:
+		// sendLoop's periodic drain will pick this up from rcs.sendQueue.
+	}
+	return nil
+}
+
+// sendLoop drains any tasks persisted in rcs.sendQueue (e.g. left over from
+// a prior leader or a restart), then handles newly queued sends as they
+// arrive, retrying failed attempts up to DefaultMaxAttempts before
+// dead-lettering them. It exits once ctx is cancelled. The initial drain
+// runs inside the spawned goroutine, not before it, since resume() calls
+// this while holding rcs.mux and a drain can take as long as
+// SendTimeout per persisted task.
+func (rcs *Service) sendLoop(ctx context.Context) {
+	go func() {
+		rcs.drainSendQueue(ctx)
+
+		ticker := time.NewTicker(SendQueuePollFreq)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case task := <-rcs.send:
+				rcs.attemptSend(ctx, task)
+			case <-ticker.C:
+				rcs.drainSendQueue(ctx)
+			}
+		}
+	}()
+}
+
+// drainSendQueue attempts delivery of every task persisted for every known
+// remote cluster, bounded by ctx so it stops promptly on shutdown or leader
+// change.
+func (rcs *Service) drainSendQueue(ctx context.Context) {
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		rcs.server.GetLogger().Error("sendLoop cannot list remote clusters", mlog.Err(err))
+		return
+	}
+
+	for _, rc := range remotes {
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			task, ok, err := rcs.sendQueue.Dequeue(rc.RemoteId)
+			if err != nil {
+				rcs.server.GetLogger().Error("sendLoop cannot dequeue task",
+					mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+				break
+			}
+			if !ok {
+				break
+			}
+			rcs.attemptSend(ctx, task)
+		}
+	}
+}
+
+// attemptSend delivers task over the remote's configured transport. On
+// failure it requeues the task for another attempt, up to
+// DefaultMaxAttempts, after which it is moved to the dead letter queue.
+func (rcs *Service) attemptSend(ctx context.Context, task sendTask) {
+	rc, err := rcs.lookupRemote(task.RemoteId)
+	if err != nil {
+		rcs.server.GetLogger().Error("sendLoop cannot find remote cluster for task",
+			mlog.String("remote_id", task.RemoteId), mlog.Err(err))
+		return
+	}
+
+	msg := task.Msg
+	if msg.Id == "" {
+		msg.Id = task.IdempotencyKey
+	}
+
+	transport := rcs.transportFor(rc)
+	_, sendErr := transport.Send(ctx, rc, msg, SendTimeout)
+	if sendErr == nil {
+		return
+	}
+
+	task.Attempts++
+	if task.Attempts >= DefaultMaxAttempts {
+		if err := rcs.sendQueue.DeadLetter(task, sendErr); err != nil {
+			rcs.server.GetLogger().Error("sendLoop cannot dead-letter task",
+				mlog.String("remote_id", task.RemoteId), mlog.Err(err))
+		}
+		return
+	}
+
+	if err := rcs.sendQueue.Requeue(task); err != nil {
+		rcs.server.GetLogger().Error("sendLoop cannot requeue task",
+			mlog.String("remote_id", task.RemoteId), mlog.Err(err))
+	}
+}
+
+// GetDeadLetters returns every dead-lettered send task for remoteId, or for
+// every remote if remoteId is "", for admin visibility into sends that
+// exhausted DefaultMaxAttempts.
+func (rcs *Service) GetDeadLetters(remoteId string) ([]DeadLetter, error) {
+	return rcs.sendQueue.DeadLetters(remoteId)
+}
+
+// pingLoop periodically pings every remote cluster to track liveness, and
+// separately drives rcs.reconciler.Tick on ReconcileFreq — its own, much
+// slower cadence, since a reconcile tick enqueues up to ReconcileItemBatch
+// catch-up items per topic for each remote found to have drifted. Both run only while this
+// cluster is the active leader. It exits once ctx is cancelled.
+func (rcs *Service) pingLoop(ctx context.Context) {
+	go func() {
+		pingTicker := time.NewTicker(PingFreq)
+		defer pingTicker.Stop()
+		reconcileTicker := time.NewTicker(ReconcileFreq)
+		defer reconcileTicker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pingTicker.C:
+				rcs.pingAll(ctx)
+			case <-reconcileTicker.C:
+				rcs.reconciler.Tick(ctx)
+			}
+		}
+	}()
+}
+
+// pingAll sends a liveness ping to every known remote cluster. Failures are
+// logged rather than propagated, since a single unreachable remote shouldn't
+// stop pingLoop from reaching the rest.
+func (rcs *Service) pingAll(ctx context.Context) {
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		rcs.server.GetLogger().Error("pingLoop cannot list remote clusters", mlog.Err(err))
+		return
+	}
+
+	for _, rc := range remotes {
+		if ctx.Err() != nil {
+			return
+		}
+
+		transport := rcs.transportFor(rc)
+		if _, err := transport.Send(ctx, rc, model.RemoteClusterMsg{Topic: PingTopic}, PingTimeout); err != nil {
+			rcs.server.GetLogger().Warn("pingLoop cannot reach remote cluster",
+				mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+		}
+	}
+}
+
+// connectGRPCRemotes opens a long-lived gRPC stream to every remote cluster
+// configured with Transport == TransportGRPC, so GRPCTransport.Send has a
+// stream ready before the first message needs to go out. Run from a
+// goroutine spawned by resume(), since dialing is network-bound and must
+// not hold rcs.mux.
+func (rcs *Service) connectGRPCRemotes(ctx context.Context) {
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		rcs.server.GetLogger().Error("cannot list remote clusters to connect grpc streams", mlog.Err(err))
+		return
+	}
+
+	for _, rc := range remotes {
+		if ctx.Err() != nil {
+			return
+		}
+		if TransportKind(rc.Transport) != TransportGRPC {
+			continue
+		}
+		if err := rcs.grpcTransport.Connect(ctx, rc); err != nil {
+			rcs.server.GetLogger().Error("cannot open grpc stream to remote cluster",
+				mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+		}
+	}
+}
+
+// disconnectGRPCRemotes tears down every gRPC stream opened by
+// connectGRPCRemotes. Run from pause() when this cluster stops being the
+// active leader, so a stale stream isn't mistaken for a live one the next
+// time this cluster resumes.
+func (rcs *Service) disconnectGRPCRemotes() {
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		rcs.server.GetLogger().Error("cannot list remote clusters to disconnect grpc streams", mlog.Err(err))
+		return
+	}
+
+	for _, rc := range remotes {
+		if TransportKind(rc.Transport) != TransportGRPC {
+			continue
+		}
+		if err := rcs.grpcTransport.Disconnect(rc); err != nil {
+			rcs.server.GetLogger().Warn("cannot close grpc stream to remote cluster",
+				mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+		}
+	}
+}
+
+// lookupRemote finds the remote cluster record for remoteId. sendTask only
+// carries the id (not the full record) so it stays cheap to persist.
+func (rcs *Service) lookupRemote(remoteId string) (*model.RemoteCluster, error) {
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, rc := range remotes {
+		if rc.RemoteId == remoteId {
+			return rc, nil
+		}
+	}
+	return nil, ErrRemoteClusterNotFound
+}
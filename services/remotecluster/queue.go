@@ -0,0 +1,257 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/store"
+)
+
+const (
+	QueueTypeMemory    = "memory"
+	QueueTypeStore     = "store"
+	DefaultMaxAttempts = 5
+)
+
+// sendTask represents a single message queued for delivery to a remote
+// cluster. IdempotencyKey is sent as the message Id (when the message has
+// none) so the receiving remote can detect and drop duplicate redeliveries
+// after an at-least-once retry.
+type sendTask struct {
+	RemoteId       string
+	Msg            model.RemoteClusterMsg
+	IdempotencyKey string
+	Attempts       int
+	QueuedAt       int64
+}
+
+// SendQueue persists outbound sendTasks so they survive a leader change or
+// process restart. Implementations must preserve FIFO order per remote.
+//
+// QueueTypeMemory does not survive a restart; it exists for small
+// deployments and tests. QueueTypeStore persists through
+// store.Store.RemoteClusterQueue() and is the one that should be configured
+// anywhere restart/failover durability matters. NewSendQueue rejects any
+// other QueueType rather than silently falling back to memory.
+type SendQueue interface {
+	// Enqueue appends task to the queue for task.RemoteId.
+	Enqueue(task sendTask) error
+
+	// Dequeue removes and returns the next task for remoteId, if any.
+	Dequeue(remoteId string) (sendTask, bool, error)
+
+	// Requeue puts task back at the front of its remote's queue, typically
+	// after a failed delivery attempt.
+	Requeue(task sendTask) error
+
+	// DeadLetter records a task that exceeded DefaultMaxAttempts so admins
+	// can inspect it later.
+	DeadLetter(task sendTask, reason error) error
+
+	// PendingRemotes returns the ids of remotes with at least one queued task.
+	PendingRemotes() ([]string, error)
+
+	// DeadLetters returns every dead-lettered task for remoteId, or for
+	// every remote if remoteId is "", for admin visibility.
+	DeadLetters(remoteId string) ([]DeadLetter, error)
+}
+
+// DeadLetter describes a sendTask that exceeded DefaultMaxAttempts, surfaced
+// through SendQueue.DeadLetters (and Service.GetDeadLetters) for admins.
+type DeadLetter struct {
+	RemoteId string
+	Topic    string
+	Payload  []byte
+	Attempts int
+	Reason   string
+	QueuedAt int64
+}
+
+// NewSendQueue creates the SendQueue backend selected by queueType.
+func NewSendQueue(queueType string, server ServerIface) (SendQueue, error) {
+	switch queueType {
+	case "", QueueTypeMemory:
+		return newMemoryQueue(), nil
+	case QueueTypeStore:
+		return newStoreQueue(server), nil
+	default:
+		return nil, fmt.Errorf("unknown remote cluster queue type %q", queueType)
+	}
+}
+
+// memoryQueue is the default SendQueue, equivalent to the original in-memory
+// channel. Queued tasks do not survive a restart.
+type memoryQueue struct {
+	mux   sync.Mutex
+	tasks map[string][]sendTask
+	dead  []DeadLetter
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		tasks: make(map[string][]sendTask),
+	}
+}
+
+func (q *memoryQueue) Enqueue(task sendTask) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.tasks[task.RemoteId] = append(q.tasks[task.RemoteId], task)
+	return nil
+}
+
+func (q *memoryQueue) Dequeue(remoteId string) (sendTask, bool, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	queue := q.tasks[remoteId]
+	if len(queue) == 0 {
+		return sendTask{}, false, nil
+	}
+	task := queue[0]
+	q.tasks[remoteId] = queue[1:]
+	return task, true, nil
+}
+
+func (q *memoryQueue) Requeue(task sendTask) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.tasks[task.RemoteId] = append([]sendTask{task}, q.tasks[task.RemoteId]...)
+	return nil
+}
+
+func (q *memoryQueue) DeadLetter(task sendTask, reason error) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.dead = append(q.dead, DeadLetter{
+		RemoteId: task.RemoteId,
+		Topic:    task.Msg.Topic,
+		Payload:  task.Msg.Payload,
+		Attempts: task.Attempts,
+		Reason:   reason.Error(),
+		QueuedAt: task.QueuedAt,
+	})
+	return nil
+}
+
+func (q *memoryQueue) PendingRemotes() ([]string, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	remotes := make([]string, 0, len(q.tasks))
+	for remoteId, queue := range q.tasks {
+		if len(queue) > 0 {
+			remotes = append(remotes, remoteId)
+		}
+	}
+	return remotes, nil
+}
+
+func (q *memoryQueue) DeadLetters(remoteId string) ([]DeadLetter, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if remoteId == "" {
+		out := make([]DeadLetter, len(q.dead))
+		copy(out, q.dead)
+		return out, nil
+	}
+
+	var out []DeadLetter
+	for _, d := range q.dead {
+		if d.RemoteId == remoteId {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// storeQueue persists sendTasks through store.Store.RemoteClusterQueue(),
+// so they survive a leader change or a process restart. It preserves FIFO
+// order per remote the same way memoryQueue does, just backed by a table
+// instead of an in-process map.
+type storeQueue struct {
+	store store.RemoteClusterQueueStore
+}
+
+func newStoreQueue(server ServerIface) *storeQueue {
+	return &storeQueue{store: server.GetStore().RemoteClusterQueue()}
+}
+
+func taskToQueueItem(task sendTask) *store.RemoteClusterQueueItem {
+	return &store.RemoteClusterQueueItem{
+		RemoteId:       task.RemoteId,
+		Topic:          task.Msg.Topic,
+		Payload:        task.Msg.Payload,
+		IdempotencyKey: task.IdempotencyKey,
+		Attempts:       task.Attempts,
+		QueuedAt:       task.QueuedAt,
+	}
+}
+
+func queueItemToTask(item *store.RemoteClusterQueueItem) sendTask {
+	return sendTask{
+		RemoteId: item.RemoteId,
+		Msg: model.RemoteClusterMsg{
+			Id:      item.IdempotencyKey,
+			Topic:   item.Topic,
+			Payload: item.Payload,
+		},
+		IdempotencyKey: item.IdempotencyKey,
+		Attempts:       item.Attempts,
+		QueuedAt:       item.QueuedAt,
+	}
+}
+
+func (q *storeQueue) Enqueue(task sendTask) error {
+	return q.store.Save(taskToQueueItem(task))
+}
+
+func (q *storeQueue) Dequeue(remoteId string) (sendTask, bool, error) {
+	item, err := q.store.PopFront(remoteId)
+	if err != nil {
+		return sendTask{}, false, err
+	}
+	if item == nil {
+		return sendTask{}, false, nil
+	}
+	return queueItemToTask(item), true, nil
+}
+
+func (q *storeQueue) Requeue(task sendTask) error {
+	return q.store.PushFront(taskToQueueItem(task))
+}
+
+func (q *storeQueue) DeadLetter(task sendTask, reason error) error {
+	item := taskToQueueItem(task)
+	item.Reason = reason.Error()
+	return q.store.SaveDeadLetter(item)
+}
+
+func (q *storeQueue) PendingRemotes() ([]string, error) {
+	return q.store.PendingRemoteIds()
+}
+
+func (q *storeQueue) DeadLetters(remoteId string) ([]DeadLetter, error) {
+	items, err := q.store.GetDeadLetters(remoteId)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]DeadLetter, 0, len(items))
+	for _, item := range items {
+		out = append(out, DeadLetter{
+			RemoteId: item.RemoteId,
+			Topic:    item.Topic,
+			Payload:  item.Payload,
+			Attempts: item.Attempts,
+			Reason:   item.Reason,
+			QueuedAt: item.QueuedAt,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueueFIFOPerRemote(t *testing.T) {
+	q := newMemoryQueue()
+
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r1", IdempotencyKey: "a"}))
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r1", IdempotencyKey: "b"}))
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r2", IdempotencyKey: "c"}))
+
+	task, ok, err := q.Dequeue("r1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", task.IdempotencyKey)
+
+	task, ok, err = q.Dequeue("r1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "b", task.IdempotencyKey)
+
+	_, ok, err = q.Dequeue("r1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	task, ok, err = q.Dequeue("r2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "c", task.IdempotencyKey)
+}
+
+func TestMemoryQueueRequeuePutsTaskBackAtFront(t *testing.T) {
+	q := newMemoryQueue()
+
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r1", IdempotencyKey: "first"}))
+	require.NoError(t, q.Requeue(sendTask{RemoteId: "r1", IdempotencyKey: "retry", Attempts: 1}))
+
+	task, ok, err := q.Dequeue("r1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "retry", task.IdempotencyKey)
+
+	task, ok, err = q.Dequeue("r1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "first", task.IdempotencyKey)
+}
+
+func TestMemoryQueueDeadLetterDoesNotResurface(t *testing.T) {
+	q := newMemoryQueue()
+
+	task := sendTask{RemoteId: "r1", IdempotencyKey: "exhausted", Attempts: DefaultMaxAttempts}
+	require.NoError(t, q.DeadLetter(task, errors.New("boom")))
+
+	_, ok, err := q.Dequeue("r1")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Len(t, q.dead, 1)
+	require.Equal(t, "boom", q.dead[0].Reason)
+}
+
+func TestMemoryQueuePendingRemotesOmitsEmptyQueues(t *testing.T) {
+	q := newMemoryQueue()
+
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r1"}))
+	require.NoError(t, q.Enqueue(sendTask{RemoteId: "r2"}))
+	_, _, err := q.Dequeue("r2")
+	require.NoError(t, err)
+
+	remotes, err := q.PendingRemotes()
+	require.NoError(t, err)
+	require.Equal(t, []string{"r1"}, remotes)
+}
+
+func TestNewSendQueueRejectsUnknownBackend(t *testing.T) {
+	_, err := NewSendQueue("redis", nil)
+	require.Error(t, err)
+}
@@ -4,8 +4,10 @@
 package remotecluster
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -50,8 +52,12 @@ type ServerIface interface {
 }
 
 // TopicListener is a callback signature used to listen for incoming messages for
-// a specific topic.
-type TopicListener func(msg model.RemoteClusterMsg, rc *model.RemoteCluster, resp Response) error
+// a specific topic. ctx should be honored by long-running handlers. For a
+// message pushed over an outbound gRPC stream it is cancelled when the
+// service is shut down or the cluster leader changes; for a request received
+// on the inbound gRPC listener it is that stream's context, cancelled when
+// the peer's stream ends.
+type TopicListener func(ctx context.Context, msg model.RemoteClusterMsg, rc *model.RemoteCluster, resp Response) error
 
 type topicListenerEntry struct {
 	id       string
@@ -81,68 +87,155 @@ func (r Response) Error() string {
 
 // Service provides inter-cluster communication via topic based messages.
 type Service struct {
-	server     ServerIface
-	send       chan sendTask
-	httpClient *http.Client
+	server        ServerIface
+	send          chan sendTask
+	sendQueue     SendQueue
+	httpTransport *HTTPTransport
+	grpcTransport *GRPCTransport
+	tlsCache      *tlsConfigCache
+	certWatcher   *certExpiryWatcher
+	reconciler    *Reconciler
 
 	// everything below guarded by `mux`
 	mux              sync.RWMutex
 	active           bool
 	leaderListenerId string
 	topicListeners   map[string]map[string]topicListenerEntry
-	done             chan struct{}
+	ctx              context.Context
+	cancel           context.CancelFunc
+	activeCtx        context.Context
+	activeCancel     context.CancelFunc
 }
 
 // NewRemoteClusterService creates a RemoteClusterService instance.
 func NewRemoteClusterService(server ServerIface) (*Service, error) {
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          200,
-		MaxIdleConnsPerHost:   2,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		DisableCompression:    false,
-	}
-
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   SendTimeout,
+	settings := server.Config().RemoteClusterSettings
+	sendQueue, err := NewSendQueue(*settings.QueueType, server)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create remote cluster send queue: %w", err)
 	}
 
+	tlsCache := newTLSConfigCache()
+
 	service := &Service{
 		server:         server,
 		send:           make(chan sendTask, SendChanBuffer),
-		httpClient:     client,
+		sendQueue:      sendQueue,
+		httpTransport:  NewHTTPTransport(tlsCache),
+		tlsCache:       tlsCache,
 		topicListeners: make(map[string]map[string]topicListenerEntry),
 	}
+	service.grpcTransport = NewGRPCTransport(server.GetLogger(), service.getTopicListeners, tlsCache, service.lookupRemote)
+	service.certWatcher = newCertExpiryWatcher(server, tlsCache, MinimumExpirationWarningInterval, DefaultExpirationWarningDays)
+	service.reconciler = newReconciler(service)
 	return service, nil
 }
 
-// Start is called by the server on server start-up.
-func (rcs *Service) Start() error {
+// RegisterReconcilable associates a Reconcilable with topic so the
+// Reconciler includes it on each tick, alongside the topic's TopicListener.
+func (rcs *Service) RegisterReconcilable(topic string, rec Reconcilable) {
+	rcs.reconciler.RegisterReconcilable(topic, rec)
+}
+
+// transportFor returns the Transport that should be used to reach rc, based
+// on rc.Transport. Remotes without an explicit preference use HTTP.
+func (rcs *Service) transportFor(rc *model.RemoteCluster) Transport {
+	if TransportKind(rc.Transport) == TransportGRPC {
+		return rcs.grpcTransport
+	}
+	return rcs.httpTransport
+}
+
+// Start is called by the server on server start-up. The supplied ctx is the
+// root of every context this service derives; cancelling it stops the send,
+// ping, reconcile and gRPC work derived from it. It does not remove the
+// leader-change listener or stop the cert expiry watcher; Shutdown does both.
+func (rcs *Service) Start(ctx context.Context) error {
 	rcs.mux.Lock()
+	rcs.ctx, rcs.cancel = context.WithCancel(ctx)
 	rcs.leaderListenerId = rcs.server.AddClusterLeaderChangedListener(rcs.onClusterLeaderChange)
 	rcs.mux.Unlock()
 
+	if err := rcs.startGRPCListener(); err != nil {
+		return err
+	}
+
 	rcs.onClusterLeaderChange()
+	rcs.certWatcher.Start()
 
 	return nil
 }
 
+// startGRPCListener starts the inbound gRPC listener peers dial into via
+// GRPCTransport.Connect, if RemoteClusterSettings.GRPCListenAddress is
+// configured. It runs independent of cluster leadership: unlike outbound
+// sends, inbound requests (e.g. a reconcile digest request) may need
+// answering even on a follower node.
+func (rcs *Service) startGRPCListener() error {
+	settings := rcs.server.Config().RemoteClusterSettings
+	if settings.GRPCListenAddress == nil || *settings.GRPCListenAddress == "" {
+		return nil
+	}
+
+	creds, err := rcs.grpcServerCredentials()
+	if err != nil {
+		return fmt.Errorf("cannot build grpc server credentials: %w", err)
+	}
+
+	if err := rcs.grpcTransport.Listen(rcs.ctx, *settings.GRPCListenAddress, creds); err != nil {
+		return fmt.Errorf("cannot start grpc remote cluster listener: %w", err)
+	}
+	return nil
+}
+
 // Shutdown is called by the server on server shutdown.
 func (rcs *Service) Shutdown() error {
 	rcs.server.RemoveClusterLeaderChangedListener(rcs.leaderListenerId)
+	rcs.certWatcher.Stop()
 	rcs.pause()
+
+	rcs.mux.Lock()
+	if rcs.cancel != nil {
+		rcs.cancel()
+	}
+	rcs.mux.Unlock()
+
 	return nil
 }
 
+// sendMsgToRemote posts msg to rc's SendMsgURL endpoint and decodes the
+// response. It is the HTTPTransport's implementation of a single send. ctx
+// bounds the request in addition to timeout, so callers can cancel a
+// specific send (e.g. on leader change) without waiting out the timeout.
+func sendMsgToRemote(ctx context.Context, client *http.Client, rc *model.RemoteCluster, msg model.RemoteClusterMsg, timeout time.Duration) (Response, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return Response{}, fmt.Errorf("cannot marshal remote cluster message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/%s", rc.SiteURL, SendMsgURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Response{}, fmt.Errorf("cannot send message to remote %s: %w", rc.RemoteId, err)
+	}
+	defer resp.Body.Close()
+
+	var response Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return Response{}, fmt.Errorf("cannot decode response from remote %s: %w", rc.RemoteId, err)
+	}
+	return response, nil
+}
+
 // AddTopicListener registers a callback
 func (rcs *Service) AddTopicListener(topic string, listener TopicListener) string {
 	rcs.mux.Lock()
@@ -209,12 +302,17 @@ func (rcs *Service) resume() {
 		return // already active
 	}
 	rcs.active = true
-	rcs.done = make(chan struct{})
+	rcs.activeCtx, rcs.activeCancel = context.WithCancel(rcs.ctx)
 
+	// connectGRPCRemotes, sendLoop, and pingLoop all hand their (possibly
+	// slow, network-bound) work off to a goroutine rather than doing it
+	// here, so a leader re-election doesn't stall AddTopicListener/
+	// RemoveTopicListener/pause/Shutdown, which also need rcs.mux.
+	go rcs.connectGRPCRemotes(rcs.activeCtx)
 	if !disablePing {
-		rcs.pingLoop(rcs.done)
+		rcs.pingLoop(rcs.activeCtx)
 	}
-	rcs.sendLoop(rcs.done)
+	rcs.sendLoop(rcs.activeCtx)
 
 	rcs.server.GetLogger().Debug("Remote Cluster Service active")
 }
@@ -227,8 +325,11 @@ func (rcs *Service) pause() {
 		return // already inactive
 	}
 	rcs.active = false
-	close(rcs.done)
-	rcs.done = nil
+	rcs.activeCancel()
+	rcs.activeCtx = nil
+	rcs.activeCancel = nil
+
+	go rcs.disconnectGRPCRemotes()
 
 	rcs.server.GetLogger().Debug("Remote Cluster Service inactive")
 }
\ No newline at end of file
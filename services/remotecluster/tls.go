@@ -0,0 +1,357 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/mattermost/mattermost-server/v5/mlog"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// MinimumExpirationWarningInterval is the minimum cadence at which the
+	// certExpiryWatcher re-checks and re-warns about a cert nearing expiry.
+	MinimumExpirationWarningInterval = time.Hour * 24
+	DefaultExpirationWarningDays     = 30
+)
+
+// certRole identifies whose certificate a checkedCert represents, so a
+// warning or admin API response can say which side needs attention.
+type certRole string
+
+const (
+	certRoleClient certRole = "client" // the cert we present when calling out to a remote
+	certRolePeer   certRole = "peer"   // a cert from the remote's CA bundle; the cert a remote actually presents is not itself tracked
+	certRoleServer certRole = "server" // the cert we serve on the inbound remotecluster API
+)
+
+// checkedCert pairs a parsed certificate with whose it is, for expiry
+// reporting.
+type checkedCert struct {
+	role certRole
+	leaf *x509.Certificate
+}
+
+// tlsConfigCache builds and caches a *tls.Config per remote cluster so the
+// (possibly expensive) certificate parsing only happens once per remote,
+// and only again after the remote's record is updated.
+type tlsConfigCache struct {
+	mux     sync.RWMutex
+	entries map[string]*tlsCacheEntry
+}
+
+type tlsCacheEntry struct {
+	config   *tls.Config
+	updateAt int64
+	certs    []checkedCert
+}
+
+func newTLSConfigCache() *tlsConfigCache {
+	return &tlsConfigCache{
+		entries: make(map[string]*tlsCacheEntry),
+	}
+}
+
+// ConfigFor returns a cached *tls.Config for rc, building (and caching) one
+// if rc has been added or updated since the last call.
+func (c *tlsConfigCache) ConfigFor(rc *model.RemoteCluster) (*tls.Config, error) {
+	entry, err := c.entryFor(rc)
+	if err != nil {
+		return nil, err
+	}
+	return entry.config, nil
+}
+
+func (c *tlsConfigCache) entryFor(rc *model.RemoteCluster) (*tlsCacheEntry, error) {
+	c.mux.RLock()
+	entry, ok := c.entries[rc.RemoteId]
+	c.mux.RUnlock()
+	if ok && entry.updateAt == rc.UpdateAt {
+		return entry, nil
+	}
+
+	entry, err := buildTLSCacheEntry(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mux.Lock()
+	c.entries[rc.RemoteId] = entry
+	c.mux.Unlock()
+
+	return entry, nil
+}
+
+// Invalidate drops any cached config for remoteId, forcing the next
+// ConfigFor call to rebuild it from the latest certificate material.
+func (c *tlsConfigCache) Invalidate(remoteId string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.entries, remoteId)
+}
+
+// buildTLSCacheEntry parses whatever certificate material rc has configured.
+// The client cert/key pair and the CA bundle are independent of one another
+// (a remote used purely to authenticate inbound callers by cert, via
+// AuthenticateByClientCert, typically has a CA bundle but no client cert of
+// our own), so each is parsed whenever present rather than the CA bundle
+// being skipped when no client cert is configured.
+func buildTLSCacheEntry(rc *model.RemoteCluster) (*tlsCacheEntry, error) {
+	entry := &tlsCacheEntry{updateAt: rc.UpdateAt}
+
+	var config *tls.Config
+
+	if rc.ClientCertPEM != "" && rc.ClientKeyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(rc.ClientCertPEM), []byte(rc.ClientKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse client certificate for remote %s: %w", rc.RemoteId, err)
+		}
+
+		config = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+
+		clientLeaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse client certificate leaf for remote %s: %w", rc.RemoteId, err)
+		}
+		entry.certs = append(entry.certs, checkedCert{role: certRoleClient, leaf: clientLeaf})
+	}
+
+	if rc.CABundlePEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(rc.CABundlePEM)) {
+			return nil, fmt.Errorf("cannot parse CA bundle for remote %s", rc.RemoteId)
+		}
+
+		if config == nil {
+			config = &tls.Config{}
+		}
+		config.RootCAs = pool
+
+		peerLeaves, err := parsePEMCertificates([]byte(rc.CABundlePEM))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse peer certificates in CA bundle for remote %s: %w", rc.RemoteId, err)
+		}
+		for _, leaf := range peerLeaves {
+			entry.certs = append(entry.certs, checkedCert{role: certRolePeer, leaf: leaf})
+		}
+	}
+
+	entry.config = config
+	return entry, nil
+}
+
+// parsePEMCertificates parses every certificate block in a PEM bundle,
+// rather than just the first, so expiry checks cover every certificate in a
+// remote's CA bundle.
+func parsePEMCertificates(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := pemBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// certExpiryWatcher periodically scans every remote cluster's client and
+// peer certificates, plus our own inbound serving certificate, and logs a
+// warning once one is within warnWithin of expiring.
+type certExpiryWatcher struct {
+	server     ServerIface
+	tlsCache   *tlsConfigCache
+	interval   time.Duration
+	warnWithin time.Duration
+
+	stop chan struct{}
+}
+
+// newCertExpiryWatcher creates a certExpiryWatcher. interval is clamped to
+// MinimumExpirationWarningInterval to avoid hammering the store.
+func newCertExpiryWatcher(server ServerIface, cache *tlsConfigCache, interval time.Duration, warnWithinDays int) *certExpiryWatcher {
+	if interval < MinimumExpirationWarningInterval {
+		interval = MinimumExpirationWarningInterval
+	}
+	if warnWithinDays <= 0 {
+		warnWithinDays = DefaultExpirationWarningDays
+	}
+
+	return &certExpiryWatcher{
+		server:     server,
+		tlsCache:   cache,
+		interval:   interval,
+		warnWithin: time.Duration(warnWithinDays) * 24 * time.Hour,
+		stop:       make(chan struct{}),
+	}
+}
+
+func (w *certExpiryWatcher) Start() {
+	go w.loop()
+}
+
+func (w *certExpiryWatcher) Stop() {
+	close(w.stop)
+}
+
+func (w *certExpiryWatcher) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.checkAll()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *certExpiryWatcher) checkAll() {
+	now := time.Now()
+
+	if serverLeaf, err := w.serverLeaf(); err != nil {
+		w.server.GetLogger().Warn("cannot parse our own remote cluster serving certificate", mlog.Err(err))
+	} else if serverLeaf != nil {
+		w.warnIfExpiring(checkedCert{role: certRoleServer, leaf: serverLeaf}, "", now)
+	}
+
+	remotes, err := w.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		w.server.GetLogger().Error("cannot list remote clusters for cert expiry check", mlog.Err(err))
+		return
+	}
+
+	for _, rc := range remotes {
+		entry, err := w.tlsCache.entryFor(rc)
+		if err != nil {
+			w.server.GetLogger().Warn("cannot build tls config for remote cluster", mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+			continue
+		}
+
+		for _, cc := range entry.certs {
+			w.warnIfExpiring(cc, rc.RemoteId, now)
+		}
+	}
+}
+
+func (w *certExpiryWatcher) warnIfExpiring(cc checkedCert, remoteId string, now time.Time) {
+	if cc.leaf.NotAfter.Sub(now) > w.warnWithin {
+		return
+	}
+	w.server.GetLogger().Warn("remote cluster certificate nearing expiry",
+		mlog.String("role", string(cc.role)),
+		mlog.String("remote_id", remoteId),
+		mlog.String("not_after", cc.leaf.NotAfter.String()))
+}
+
+// serverLeaf parses our own inbound-facing serving certificate, configured
+// globally (not per-remote) via RemoteClusterSettings.ServerCertPEM.
+func (w *certExpiryWatcher) serverLeaf() (*x509.Certificate, error) {
+	settings := w.server.Config().RemoteClusterSettings
+	if settings.ServerCertPEM == nil || *settings.ServerCertPEM == "" {
+		return nil, nil
+	}
+
+	certs, err := parsePEMCertificates([]byte(*settings.ServerCertPEM))
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	return certs[0], nil
+}
+
+// grpcServerCredentials builds transport credentials for the inbound gRPC
+// remote cluster listener from RemoteClusterSettings.ServerCertPEM/
+// ServerKeyPEM. It returns a nil credentials.TransportCredentials (and no
+// error) when no server certificate is configured, in which case the
+// listener serves plaintext.
+func (rcs *Service) grpcServerCredentials() (credentials.TransportCredentials, error) {
+	settings := rcs.server.Config().RemoteClusterSettings
+	if settings.ServerCertPEM == nil || *settings.ServerCertPEM == "" {
+		return nil, nil
+	}
+	if settings.ServerKeyPEM == nil || *settings.ServerKeyPEM == "" {
+		return nil, fmt.Errorf("RemoteClusterSettings.ServerCertPEM is set but ServerKeyPEM is not")
+	}
+
+	cert, err := tls.X509KeyPair([]byte(*settings.ServerCertPEM), []byte(*settings.ServerKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse grpc server certificate: %w", err)
+	}
+	return credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), nil
+}
+
+// CertExpiryInfo is the shape surfaced through the admin API for a single
+// certificate tracked by the cert expiry watcher.
+type CertExpiryInfo struct {
+	Role     string    `json:"role"`
+	RemoteId string    `json:"remote_id,omitempty"`
+	NotAfter time.Time `json:"not_after"`
+	Expiring bool      `json:"expiring"`
+}
+
+// GetCertExpiryInfo reports the expiry status of every certificate the
+// cert expiry watcher tracks (our serving cert, and each remote's client
+// and peer certificates), for use by the admin API.
+func (rcs *Service) GetCertExpiryInfo(warnWithinDays int) ([]CertExpiryInfo, error) {
+	if warnWithinDays <= 0 {
+		warnWithinDays = DefaultExpirationWarningDays
+	}
+	warnWithin := time.Duration(warnWithinDays) * 24 * time.Hour
+	now := time.Now()
+
+	var infos []CertExpiryInfo
+
+	if serverLeaf, err := rcs.certWatcher.serverLeaf(); err == nil && serverLeaf != nil {
+		infos = append(infos, CertExpiryInfo{
+			Role:     string(certRoleServer),
+			NotAfter: serverLeaf.NotAfter,
+			Expiring: serverLeaf.NotAfter.Sub(now) <= warnWithin,
+		})
+	}
+
+	remotes, err := rcs.server.GetStore().RemoteCluster().GetAll(false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list remote clusters: %w", err)
+	}
+
+	for _, rc := range remotes {
+		entry, err := rcs.tlsCache.entryFor(rc)
+		if err != nil {
+			continue
+		}
+		for _, cc := range entry.certs {
+			infos = append(infos, CertExpiryInfo{
+				Role:     string(cc.role),
+				RemoteId: rc.RemoteId,
+				NotAfter: cc.leaf.NotAfter,
+				Expiring: cc.leaf.NotAfter.Sub(now) <= warnWithin,
+			})
+		}
+	}
+	return infos, nil
+}
@@ -0,0 +1,557 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package remotecluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/mattermost/mattermost-server/v5/mlog"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/services/remotecluster/rpc"
+)
+
+// TransportKind identifies which Transport implementation a remote cluster
+// should use. Admins select this per-remote via model.RemoteCluster.
+type TransportKind string
+
+const (
+	TransportHTTP TransportKind = "http"
+	TransportGRPC TransportKind = "grpc"
+)
+
+const (
+	grpcInitialBackoff = 500 * time.Millisecond
+	grpcMaxBackoff     = time.Minute
+)
+
+// Transport abstracts how a single message (and its response) travels to a
+// remote cluster. HTTPTransport issues one request per message; GRPCTransport
+// multiplexes sends onto a long-lived bidirectional stream.
+type Transport interface {
+	// Send delivers msg to rc and returns the remote's response. ctx bounds
+	// the send in addition to timeout, and is honored by the underlying
+	// http.Request or grpc call so a caller can cancel a specific send.
+	Send(ctx context.Context, rc *model.RemoteCluster, msg model.RemoteClusterMsg, timeout time.Duration) (Response, error)
+
+	// Connect prepares the transport for use with rc (e.g. opening a stream).
+	// HTTPTransport treats this as a no-op. ctx governs the lifetime of any
+	// resources opened for rc; it is normally the Service's active context,
+	// so a leader change or shutdown tears the connection down.
+	Connect(ctx context.Context, rc *model.RemoteCluster) error
+
+	// Disconnect tears down any per-remote resources held for rc.
+	Disconnect(rc *model.RemoteCluster) error
+}
+
+// HTTPTransport sends each message as an individual POST to SendMsgURL,
+// matching the Service's historical behavior. When a remote has client
+// certificates configured, requests to it use mTLS instead of the bearer
+// token alone; the per-remote client (and its connection pool) is cached
+// the same way tlsConfigCache caches the underlying *tls.Config, so an
+// mTLS-configured remote doesn't lose keep-alive on every send.
+type HTTPTransport struct {
+	client    *http.Client
+	tlsCache  *tlsConfigCache
+	mtlsMux   sync.Mutex
+	mtlsCache map[string]*mtlsClientEntry
+}
+
+type mtlsClientEntry struct {
+	client   *http.Client
+	updateAt int64
+}
+
+// NewHTTPTransport creates an HTTPTransport using a client tuned for
+// short-lived, high-frequency inter-cluster requests.
+func NewHTTPTransport(tlsCache *tlsConfigCache) *HTTPTransport {
+	return &HTTPTransport{
+		client:    newPooledHTTPClient(nil),
+		tlsCache:  tlsCache,
+		mtlsCache: make(map[string]*mtlsClientEntry),
+	}
+}
+
+func newPooledHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				DualStack: true,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          200,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			DisableCompression:    false,
+			TLSClientConfig:       tlsConfig,
+		},
+		Timeout: SendTimeout,
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, rc *model.RemoteCluster, msg model.RemoteClusterMsg, timeout time.Duration) (Response, error) {
+	client, err := t.clientFor(rc)
+	if err != nil {
+		return Response{}, fmt.Errorf("cannot build tls config for remote %s: %w", rc.RemoteId, err)
+	}
+
+	return sendMsgToRemote(ctx, client, rc, msg, timeout)
+}
+
+// clientFor returns the *http.Client to use for rc: the shared client when
+// rc has neither a client certificate nor a CA bundle, or a cached
+// per-remote client built with its TLS config (mTLS when a client
+// certificate is configured). The cached client is rebuilt only when rc has
+// been updated since it was cached.
+func (t *HTTPTransport) clientFor(rc *model.RemoteCluster) (*http.Client, error) {
+	tlsConfig, err := t.tlsCache.ConfigFor(rc)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return t.client, nil
+	}
+
+	t.mtlsMux.Lock()
+	defer t.mtlsMux.Unlock()
+
+	if entry, ok := t.mtlsCache[rc.RemoteId]; ok && entry.updateAt == rc.UpdateAt {
+		return entry.client, nil
+	}
+
+	client := newPooledHTTPClient(tlsConfig)
+	t.mtlsCache[rc.RemoteId] = &mtlsClientEntry{client: client, updateAt: rc.UpdateAt}
+	return client, nil
+}
+
+func (t *HTTPTransport) Connect(ctx context.Context, rc *model.RemoteCluster) error {
+	return nil
+}
+
+func (t *HTTPTransport) Disconnect(rc *model.RemoteCluster) error {
+	return nil
+}
+
+// remoteStream tracks the connection state for a single remote cluster's
+// gRPC stream, including the backoff used while reconnecting and the sends
+// awaiting a response on that stream.
+type remoteStream struct {
+	conn    *grpc.ClientConn
+	client  rpc.RemoteClusterServiceClient
+	stream  rpc.RemoteClusterService_MessageStreamClient
+	cancel  context.CancelFunc
+	backoff time.Duration
+
+	pendingMux sync.Mutex
+	pending    map[string]chan *rpc.RemoteClusterEnvelope
+}
+
+func newRemoteStream(conn *grpc.ClientConn, client rpc.RemoteClusterServiceClient, stream rpc.RemoteClusterService_MessageStreamClient, cancel context.CancelFunc) *remoteStream {
+	return &remoteStream{
+		conn:    conn,
+		client:  client,
+		stream:  stream,
+		cancel:  cancel,
+		backoff: grpcInitialBackoff,
+		pending: make(map[string]chan *rpc.RemoteClusterEnvelope),
+	}
+}
+
+// await registers correlationId as awaiting a response and returns the
+// channel it will be delivered on, along with a cleanup func the caller must
+// run once it stops waiting (whether or not a response arrived).
+func (rs *remoteStream) await(correlationId string) (chan *rpc.RemoteClusterEnvelope, func()) {
+	ch := make(chan *rpc.RemoteClusterEnvelope, 1)
+
+	rs.pendingMux.Lock()
+	rs.pending[correlationId] = ch
+	rs.pendingMux.Unlock()
+
+	return ch, func() {
+		rs.pendingMux.Lock()
+		delete(rs.pending, correlationId)
+		rs.pendingMux.Unlock()
+	}
+}
+
+// deliver routes an incoming response envelope to its waiter, if one is
+// still registered. It returns false if nothing was waiting for it.
+func (rs *remoteStream) deliver(envelope *rpc.RemoteClusterEnvelope) bool {
+	rs.pendingMux.Lock()
+	ch, ok := rs.pending[envelope.CorrelationId]
+	rs.pendingMux.Unlock()
+	if !ok {
+		return false
+	}
+
+	select {
+	case ch <- envelope:
+	default:
+	}
+	return true
+}
+
+// GRPCTransport carries messages over a long-lived bidirectional gRPC stream
+// per remote cluster, avoiding a TLS handshake on every send and allowing the
+// remote to push messages without being polled. Outbound sends and their
+// responses are multiplexed onto the same stream and matched by
+// CorrelationId; any envelope that isn't a response is treated as a
+// server-initiated push and dispatched to topic listeners, and a response
+// with no pending send is dropped.
+//
+// GRPCTransport is also the inbound side of the same protocol: Listen starts
+// a grpc.Server registering it as the RemoteClusterServiceServer, so a peer
+// dialing us via its own GRPCTransport.Connect reaches MessageStream
+// below, which dispatches to the same listeners(topic) and replies on the
+// stream.
+type GRPCTransport struct {
+	logger       mlog.LoggerIFace
+	listeners    func(topic string) []TopicListener
+	tlsCache     *tlsConfigCache
+	lookupRemote func(remoteId string) (*model.RemoteCluster, error)
+
+	mux        sync.Mutex
+	streams    map[string]*remoteStream
+	grpcServer *grpc.Server
+}
+
+// NewGRPCTransport creates a GRPCTransport. Streams are opened lazily via
+// Connect as each remote cluster resumes, and dispatches server-pushed
+// messages to listeners(topic). Remotes with a client certificate or CA
+// bundle configured in tlsCache dial over TLS (mTLS when a client
+// certificate is configured); all others fall back to plaintext.
+// lookupRemote resolves the model.RemoteCluster record identified by an
+// inbound envelope's RemoteId, for handling requests on the server side.
+func NewGRPCTransport(logger mlog.LoggerIFace, listeners func(topic string) []TopicListener, tlsCache *tlsConfigCache, lookupRemote func(remoteId string) (*model.RemoteCluster, error)) *GRPCTransport {
+	return &GRPCTransport{
+		logger:       logger,
+		listeners:    listeners,
+		tlsCache:     tlsCache,
+		lookupRemote: lookupRemote,
+		streams:      make(map[string]*remoteStream),
+	}
+}
+
+// Listen starts a grpc.Server on addr accepting inbound MessageStream
+// connections from remote clusters dialing us, registering this
+// GRPCTransport as the RemoteClusterServiceServer. creds may be nil, in
+// which case the listener serves plaintext (e.g. when no server certificate
+// is configured). The server is stopped when ctx is cancelled.
+func (t *GRPCTransport) Listen(ctx context.Context, addr string, creds credentials.TransportCredentials) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	var opts []grpc.ServerOption
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(opts...)
+	rpc.RegisterRemoteClusterServiceServer(server, t)
+
+	t.mux.Lock()
+	t.grpcServer = server
+	t.mux.Unlock()
+
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			t.logger.Error("grpc remote cluster listener stopped", mlog.Err(err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	return nil
+}
+
+// MessageStream implements rpc.RemoteClusterServiceServer, handling a single
+// inbound connection from a remote cluster's GRPCTransport.Connect. Every
+// non-response envelope received is dispatched to listeners(topic) and a
+// response envelope is always sent back, since the sender's Send is blocked
+// waiting for one.
+func (t *GRPCTransport) MessageStream(stream rpc.RemoteClusterService_MessageStreamServer) error {
+	for {
+		envelope, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if envelope.IsResponse {
+			// Inbound connections only ever carry requests from the dialer;
+			// we have no pending send of our own on this stream to match.
+			continue
+		}
+		if err := stream.Send(t.handleInbound(stream.Context(), envelope)); err != nil {
+			return err
+		}
+	}
+}
+
+// handleInbound dispatches a single inbound request envelope to
+// listeners(topic) and builds the response envelope to send back.
+func (t *GRPCTransport) handleInbound(ctx context.Context, envelope *rpc.RemoteClusterEnvelope) *rpc.RemoteClusterEnvelope {
+	reply := &rpc.RemoteClusterEnvelope{
+		CorrelationId: envelope.CorrelationId,
+		IsResponse:    true,
+		Status:        ResponseStatusOK,
+	}
+
+	rc, err := t.lookupRemote(envelope.RemoteId)
+	if err != nil {
+		reply.Status = ResponseStatusFail
+		reply.Error = err.Error()
+		return reply
+	}
+
+	msg := model.RemoteClusterMsg{
+		Id:      envelope.CorrelationId,
+		Topic:   envelope.Topic,
+		Payload: envelope.Payload,
+	}
+
+	resp := Response{}
+	for _, listener := range t.listeners(envelope.Topic) {
+		if err := listener(ctx, msg, rc, resp); err != nil {
+			reply.Status = ResponseStatusFail
+			reply.Error = err.Error()
+			return reply
+		}
+	}
+
+	if len(resp) > 0 {
+		if payload, err := json.Marshal(resp); err == nil {
+			reply.Payload = payload
+		} else {
+			t.logger.Warn("cannot marshal response payload for grpc request",
+				mlog.String("remote_id", envelope.RemoteId), mlog.String("topic", envelope.Topic), mlog.Err(err))
+		}
+	}
+
+	return reply
+}
+
+func (t *GRPCTransport) Connect(ctx context.Context, rc *model.RemoteCluster) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if _, ok := t.streams[rc.RemoteId]; ok {
+		return nil // already connected
+	}
+
+	rs, err := t.dial(ctx, rc)
+	if err != nil {
+		return fmt.Errorf("cannot open grpc stream to remote %s: %w", rc.RemoteId, err)
+	}
+	t.streams[rc.RemoteId] = rs
+
+	go t.recvLoop(ctx, rc, rs)
+	return nil
+}
+
+func (t *GRPCTransport) dial(ctx context.Context, rc *model.RemoteCluster) (*remoteStream, error) {
+	creds, err := t.dialCredentials(rc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build grpc credentials for remote %s: %w", rc.RemoteId, err)
+	}
+
+	conn, err := grpc.DialContext(ctx, rc.SiteURL, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		return nil, err
+	}
+	client := rpc.NewRemoteClusterServiceClient(conn)
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := client.MessageStream(streamCtx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, err
+	}
+
+	return newRemoteStream(conn, client, stream, cancel), nil
+}
+
+// dialCredentials returns TLS credentials built from rc's cached config when
+// rc has a client certificate or CA bundle configured (mTLS when a client
+// certificate is present), and explicit plaintext credentials otherwise — grpc-go
+// refuses to dial without any transport security configured at all.
+func (t *GRPCTransport) dialCredentials(rc *model.RemoteCluster) (credentials.TransportCredentials, error) {
+	tlsConfig, err := t.tlsCache.ConfigFor(rc)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return insecure.NewCredentials(), nil
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// recvLoop reads envelopes off the stream, routing responses to the send
+// awaiting them and dispatching anything else (server-initiated pushes) to
+// topic listeners. It reconnects with exponential backoff on failure and
+// exits once ctx is cancelled (service shutdown or leader change).
+func (t *GRPCTransport) recvLoop(ctx context.Context, rc *model.RemoteCluster, rs *remoteStream) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		envelope, err := rs.stream.Recv()
+		if err != nil {
+			t.logger.Warn("grpc stream to remote cluster lost, reconnecting",
+				mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+			next, ok := t.reconnect(ctx, rc, rs)
+			if !ok {
+				return
+			}
+			rs = next
+			continue
+		}
+
+		if envelope.IsResponse {
+			if rs.deliver(envelope) {
+				continue
+			}
+			// No one is waiting for this response anymore (e.g. the send
+			// already timed out); drop it rather than misrouting it to a
+			// topic listener.
+			continue
+		}
+
+		msg := model.RemoteClusterMsg{
+			Id:      envelope.CorrelationId,
+			Topic:   envelope.Topic,
+			Payload: envelope.Payload,
+		}
+
+		for _, listener := range t.listeners(envelope.Topic) {
+			if err := listener(ctx, msg, rc, Response{}); err != nil {
+				t.logger.Error("topic listener failed for grpc message",
+					mlog.String("remote_id", rc.RemoteId), mlog.String("topic", envelope.Topic), mlog.Err(err))
+			}
+		}
+	}
+}
+
+// reconnect redials rs after an exponential backoff. It returns false when
+// ctx was cancelled or the remote was disconnected concurrently, and the
+// caller should stop retrying.
+func (t *GRPCTransport) reconnect(ctx context.Context, rc *model.RemoteCluster, rs *remoteStream) (*remoteStream, bool) {
+	t.mux.Lock()
+	if _, ok := t.streams[rc.RemoteId]; !ok {
+		t.mux.Unlock()
+		return nil, false
+	}
+	t.mux.Unlock()
+
+	select {
+	case <-time.After(rs.backoff):
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	backoff := rs.backoff * 2
+	if backoff > grpcMaxBackoff {
+		backoff = grpcMaxBackoff
+	}
+
+	newRS, err := t.dial(ctx, rc)
+	if err != nil {
+		rs.backoff = backoff
+		return rs, true
+	}
+	newRS.backoff = backoff
+
+	t.mux.Lock()
+	t.streams[rc.RemoteId] = newRS
+	t.mux.Unlock()
+
+	return newRS, true
+}
+
+// Send multiplexes msg onto the remote's stream and waits for the response
+// envelope carrying the same CorrelationId, which recvLoop routes back here
+// instead of to topic listeners.
+func (t *GRPCTransport) Send(ctx context.Context, rc *model.RemoteCluster, msg model.RemoteClusterMsg, timeout time.Duration) (Response, error) {
+	t.mux.Lock()
+	rs, ok := t.streams[rc.RemoteId]
+	t.mux.Unlock()
+	if !ok {
+		return Response{}, fmt.Errorf("no active grpc stream for remote %s", rc.RemoteId)
+	}
+
+	correlationId := msg.Id
+	if correlationId == "" {
+		correlationId = model.NewId()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	respCh, cleanup := rs.await(correlationId)
+	defer cleanup()
+
+	if err := rs.stream.Send(&rpc.RemoteClusterEnvelope{
+		Topic:         msg.Topic,
+		Payload:       msg.Payload,
+		CorrelationId: correlationId,
+		RemoteId:      rc.RemoteId,
+	}); err != nil {
+		return Response{}, fmt.Errorf("grpc send to remote %s failed: %w", rc.RemoteId, err)
+	}
+
+	select {
+	case envelope := <-respCh:
+		if envelope.Error != "" {
+			return Response{ResponseStatusKey: ResponseStatusFail, ResponseErrorKey: envelope.Error}, nil
+		}
+
+		resp := Response{ResponseStatusKey: envelope.Status}
+		if len(envelope.Payload) > 0 {
+			var extra Response
+			if err := json.Unmarshal(envelope.Payload, &extra); err != nil {
+				t.logger.Warn("cannot unmarshal response payload from remote",
+					mlog.String("remote_id", rc.RemoteId), mlog.Err(err))
+			} else {
+				for k, v := range extra {
+					resp[k] = v
+				}
+			}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return Response{}, fmt.Errorf("timed out waiting for response from remote %s: %w", rc.RemoteId, ctx.Err())
+	}
+}
+
+func (t *GRPCTransport) Disconnect(rc *model.RemoteCluster) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	rs, ok := t.streams[rc.RemoteId]
+	if !ok {
+		return nil
+	}
+	delete(t.streams, rc.RemoteId)
+	rs.cancel()
+	return rs.conn.Close()
+}